@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"net/url"
+	"text/template"
+	"time"
 
 	"strconv"
 	"strings"
@@ -13,6 +17,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 )
 
 func (c *Controller) enqueueDatabase(obj interface{}) {
@@ -44,6 +49,17 @@ func (c *Controller) syncDatabase(key string) error {
 		return err
 	}
 
+	if db.ObjectMeta.DeletionTimestamp != nil {
+		return c.finalizeDatabase(key, db)
+	}
+
+	if !containsString(db.ObjectMeta.Finalizers, DatabaseFinalizer) {
+		db, err = c.addFinalizer(db)
+		if err != nil {
+			return err
+		}
+	}
+
 	if db.Status.State == "" {
 		c.updateDatabaseStatus(key, db, StatePending, "Yet to initialize")
 	}
@@ -90,8 +106,19 @@ func (c *Controller) syncDatabase(key string) error {
 
 	// If dsn/dsnFrom is passed in the database spec consider as override and don't go through database spec
 	dsn := db.Spec.Dsn
+	var vaultLease time.Duration
 	if dsn == "" {
-		if db.Spec.DsnFrom != nil {
+		if db.Spec.DsnFrom != nil && db.Spec.DsnFrom.VaultKeyRef != nil {
+			secret, err := c.vaultClient.ReadKeyRef(db.Spec.DsnFrom.VaultKeyRef)
+			if err != nil {
+				msg := fmt.Sprintf("failed to get valid DSN for database `%s` from vault path `%s`: %s", key, db.Spec.DsnFrom.VaultKeyRef.Path, err)
+				c.logger.Error(msg)
+				c.updateDatabaseStatus(key, db, StateError, msg)
+				return nil
+			}
+			dsn = secret.Value
+			vaultLease = secret.LeaseDuration
+		} else if db.Spec.DsnFrom != nil {
 			secretName := db.Spec.DsnFrom.SecretKeyRef.Name
 			dsn, err = c.getSecretFromValueSource(db.Namespace, db.Spec.DsnFrom)
 			if err != nil {
@@ -124,18 +151,40 @@ func (c *Controller) syncDatabase(key string) error {
 	}
 
 	// Update dsn related to a database which databaseschema will use.
+	// Deprecated: db.Spec.Users only ever provisions a secret for the
+	// "admin" role (see syncDatabaseSecret below). Prefer a DatabaseUser
+	// resource per role instead; it gets its own lifecycle, password
+	// rotation, and connection Secret via pkg/controller/databaseuser.
 	for index, user := range db.Spec.Users {
-		if user.PasswordFrom != nil {
-			passwd, err := c.getSecretFromValueSource(db.Namespace, user.PasswordFrom)
+		if user.PasswordFrom == nil {
+			continue
+		}
+		var passwd string
+		if user.PasswordFrom.VaultKeyRef != nil {
+			secret, err := c.vaultClient.ReadKeyRef(user.PasswordFrom.VaultKeyRef)
+			if err != nil {
+				msg := fmt.Sprintf("failed to get valid password for user `%s` from vault path `%s`: %s", user.Name, user.PasswordFrom.VaultKeyRef.Path, err)
+				c.logger.Error(msg)
+				c.updateDatabaseStatus(key, db, StateError, msg)
+				return nil
+			}
+			passwd = secret.Value
+		} else {
+			var err error
+			passwd, err = c.getSecretFromValueSource(db.Namespace, user.PasswordFrom)
 			if err != nil {
 				if errors.IsNotFound(err) {
 					msg := fmt.Sprintf("waiting for secret or configmap for %s", user.Name)
 					c.updateDatabaseStatus(key, db, StatePending, msg)
 					return err
 				}
+				msg := fmt.Sprintf("failed to get valid password for user `%s`: %s", user.Name, err)
+				c.logger.Error(msg)
+				c.updateDatabaseStatus(key, db, StateError, msg)
+				return nil
 			}
-			db.Spec.Users[index].Password = passwd
 		}
+		db.Spec.Users[index].Password = passwd
 	}
 
 	state, err := p.SyncDatabase(db, dsn)
@@ -150,18 +199,40 @@ func (c *Controller) syncDatabase(key string) error {
 		c.recorder.Event(db, corev1.EventTypeNormal, StateCreated, msg)
 	}
 
-	err = c.syncDatabaseSecret(key, dsn, db, s, p)
+	err = c.syncDatabaseSecret(key, dsn, db, s, p, vaultLease > 0)
 	if err != nil {
 		msg := fmt.Sprintf("error syncing database secrets '%s': %s", key, err)
 		c.updateDatabaseStatus(key, db, StateError, msg)
 		return nil
 	}
 
-	c.updateDatabaseStatus(key, db, StateSuccess, fmt.Sprintf(MessageDatabaseSynced, key))
+	if updated, err := c.updateDatabaseStatus(key, db, StateSuccess, fmt.Sprintf(MessageDatabaseSynced, key)); err == nil {
+		// updateDatabaseStatus just bumped db's resourceVersion server-side;
+		// check drift against the refreshed copy so its own Update below
+		// doesn't lose to a conflict against our now-stale db.
+		db = updated
+	}
+
+	c.checkDrift(key, db, dsn, p)
+
+	if vaultLease > 0 {
+		// Re-enqueue ahead of lease expiry so the generated Secret is
+		// rotated to a fresh credential before Vault revokes this one.
+		rotateIn := vaultLease - vaultLease/4
+		c.logger.Debugf("database '%s' sourced from vault lease, rotating secret in %s", key, rotateIn)
+		c.dbQueue.AddAfter(key, rotateIn)
+		c.recorder.Event(db, corev1.EventTypeNormal, "VaultLeaseRenewal", fmt.Sprintf("rotating vault-sourced credentials for %q in %s", key, rotateIn))
+	}
+
 	return nil
 }
 
-func (c *Controller) syncDatabaseSecret(key, dsn string, db *atlas.Database, dbServer *atlas.DatabaseServer, dbPlugin plugin.DatabasePlugin) error {
+// syncDatabaseSecret creates the admin connection Secret for db the first
+// time it's needed. When rotating (dsn sourced from a Vault lease that's
+// about to be re-read on the next sync), it instead updates the existing
+// Secret's data in place so Dsn/buildSecretData reflect the newly issued
+// credential.
+func (c *Controller) syncDatabaseSecret(key, dsn string, db *atlas.Database, dbServer *atlas.DatabaseServer, dbPlugin plugin.DatabasePlugin, rotating bool) error {
 	if db.Spec.Users == nil {
 		c.logger.Debug(" Database users not provided. Skip database secret creation")
 		return nil
@@ -183,24 +254,37 @@ func (c *Controller) syncDatabaseSecret(key, dsn string, db *atlas.Database, dbS
 		if user.Role == "admin" {
 			if errors.IsNotFound(err) {
 				c.logger.Info("Database secret not found for %s. Creating...", key)
+				var host string
+				var port int32
 				if dbServer != nil {
 					dsn = dbPlugin.Dsn(user.Name, passwd, db, dbServer)
+					host, port = dbServer.Spec.DBHost, dbServer.Spec.ServicePort
 				} else {
 					customDbServer := &atlas.DatabaseServer{}
-					host, port := c.getHostAndPort(dsn)
+					host, port = c.getHostAndPort(dsn)
 					customDbServer.Spec.DBHost = host
 					customDbServer.Spec.ServicePort = port
 					dsn = dbPlugin.Dsn(user.Name, passwd, db, customDbServer)
 				}
 
+				data, err := c.buildSecretData(db, dsn, user.Name, passwd, host, port, dbPlugin)
+				if err != nil {
+					msg := fmt.Sprintf("invalid secretTemplate for database '%s': %s", key, err)
+					c.logger.Error(msg)
+					c.updateDatabaseStatus(key, db, StateError, msg)
+					return err
+				}
+
+				// Create races against another controller instance would
+				// surface as IsAlreadyExists, not IsConflict, so Create is
+				// not retried here; the error is returned and a later sync
+				// re-evaluates against whatever secret exists by then.
 				secret, err = c.kubeclientset.CoreV1().Secrets(db.Namespace).Create(
 					&corev1.Secret{
 						ObjectMeta: c.objMeta(db, "Secret"),
-						StringData: map[string]string{"dsn": dsn},
+						StringData: data,
 					},
 				)
-				// If an error occurs during Create, we'll requeue the item so we can
-				// attempt processing again later.
 				if err != nil {
 					msg := fmt.Sprintf("failed to create secret '%s': %s", key, err)
 					c.logger.Error(msg)
@@ -208,6 +292,39 @@ func (c *Controller) syncDatabaseSecret(key, dsn string, db *atlas.Database, dbS
 					return err
 				}
 				c.recorder.Event(db, corev1.EventTypeNormal, StateCreated, fmt.Sprintf(MessageSecretCreated, secret.Name))
+			} else if rotating {
+				var host string
+				var port int32
+				if dbServer != nil {
+					dsn = dbPlugin.Dsn(user.Name, passwd, db, dbServer)
+					host, port = dbServer.Spec.DBHost, dbServer.Spec.ServicePort
+				} else {
+					customDbServer := &atlas.DatabaseServer{}
+					host, port = c.getHostAndPort(dsn)
+					customDbServer.Spec.DBHost = host
+					customDbServer.Spec.ServicePort = port
+					dsn = dbPlugin.Dsn(user.Name, passwd, db, customDbServer)
+				}
+
+				data, err := c.buildSecretData(db, dsn, user.Name, passwd, host, port, dbPlugin)
+				if err != nil {
+					msg := fmt.Sprintf("invalid secretTemplate for database '%s': %s", key, err)
+					c.logger.Error(msg)
+					c.updateDatabaseStatus(key, db, StateError, msg)
+					return err
+				}
+
+				copy := secret.DeepCopy()
+				copy.StringData = data
+				updated, err := c.kubeclientset.CoreV1().Secrets(db.Namespace).Update(copy)
+				if err != nil {
+					msg := fmt.Sprintf("failed to rotate secret '%s': %s", key, err)
+					c.logger.Error(msg)
+					c.updateDatabaseStatus(key, db, StateError, msg)
+					return err
+				}
+				secret = updated
+				c.logger.Infof("rotated vault-sourced credentials in secret '%s'", key)
 			}
 		}
 	}
@@ -224,6 +341,258 @@ func (c *Controller) syncDatabaseSecret(key, dsn string, db *atlas.Database, dbS
 	return nil
 }
 
+// secretTemplateContext is the fixed set of fields available to
+// Database.Spec.SecretTemplate entries.
+type secretTemplateContext struct {
+	Host     string
+	Port     int32
+	User     string
+	Password string
+	Database string
+	SSLMode  string
+	Protocol string
+}
+
+// jdbcSchemes maps a plugin's Protocol() to the scheme JDBC drivers expect;
+// they don't always match (e.g. "postgres" -> "postgresql").
+var jdbcSchemes = map[string]string{
+	"postgres": "postgresql",
+}
+
+func jdbcURL(protocol, host string, port int32, database string) string {
+	scheme, ok := jdbcSchemes[protocol]
+	if !ok {
+		scheme = protocol
+	}
+	return fmt.Sprintf("jdbc:%s://%s:%d/%s", scheme, host, port, database)
+}
+
+// sslModeFromDSN extracts the sslmode query parameter from dsn, defaulting
+// to "disable" when it's absent or dsn doesn't parse as a URL, so templates
+// don't end up claiming a stronger mode than the connection actually uses.
+func sslModeFromDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "disable"
+	}
+	if mode := u.Query().Get("sslmode"); mode != "" {
+		return mode
+	}
+	return "disable"
+}
+
+// buildSecretData assembles the StringData for a database connection Secret.
+// The legacy `dsn` key is always kept for backward compatibility, HOST/PORT/
+// USER/PASSWORD/DATABASE/JDBC_URL are always populated, and any keys declared
+// in db.Spec.SecretTemplate are rendered on top via text/template.
+func (c *Controller) buildSecretData(db *atlas.Database, dsn, user, passwd, host string, port int32, dbPlugin plugin.DatabasePlugin) (map[string]string, error) {
+	protocol := dbPlugin.Protocol()
+
+	data := map[string]string{
+		"dsn":      dsn,
+		"HOST":     host,
+		"PORT":     strconv.Itoa(int(port)),
+		"USER":     user,
+		"PASSWORD": passwd,
+		"DATABASE": db.Name,
+		"JDBC_URL": jdbcURL(protocol, host, port, db.Name),
+	}
+
+	if len(db.Spec.SecretTemplate) == 0 {
+		return data, nil
+	}
+
+	ctx := secretTemplateContext{
+		Host:     host,
+		Port:     port,
+		User:     user,
+		Password: passwd,
+		Database: db.Name,
+		SSLMode:  sslModeFromDSN(dsn),
+		Protocol: protocol,
+	}
+
+	for key, tmplText := range db.Spec.SecretTemplate {
+		tmpl, err := template.New(key).Parse(tmplText)
+		if err != nil {
+			return nil, fmt.Errorf("secretTemplate entry %q: %s", key, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, ctx); err != nil {
+			return nil, fmt.Errorf("secretTemplate entry %q: %s", key, err)
+		}
+		data[key] = buf.String()
+	}
+
+	return data, nil
+}
+
+// DatabaseFinalizer is set on every Database resource so its deletion can be
+// intercepted and run through reclaim-policy checks before the underlying
+// database is dropped.
+const DatabaseFinalizer = "database.atlas-db.infobloxopen.com/finalizer"
+
+// ReclaimPolicyAnnotation overrides the operator-wide deletion-protection
+// flags for a single Database resource.
+const ReclaimPolicyAnnotation = "atlas-db.infobloxopen.com/reclaim-policy"
+
+const (
+	ReclaimPolicyRetain = "Retain"
+	ReclaimPolicyDelete = "Delete"
+)
+
+func (c *Controller) addFinalizer(db *atlas.Database) (*atlas.Database, error) {
+	copy := db.DeepCopy()
+	copy.ObjectMeta.Finalizers = append(copy.ObjectMeta.Finalizers, DatabaseFinalizer)
+	return c.atlasclientset.AtlasdbV1alpha1().Databases(db.Namespace).Update(copy)
+}
+
+// finalizeDatabase runs when a Database is being deleted. It honors the
+// effective reclaim policy: Delete drops the underlying database/schema
+// before letting Kubernetes remove the resource, Retain only removes the
+// finalizer and leaves the database server-side data untouched.
+func (c *Controller) finalizeDatabase(key string, db *atlas.Database) error {
+	if !containsString(db.ObjectMeta.Finalizers, DatabaseFinalizer) {
+		return nil
+	}
+
+	if updated, err := c.updateDatabaseStatus(key, db, StateTerminating, "Database is being deleted"); err == nil {
+		// The status update bumped db's resourceVersion server-side; use the
+		// refreshed copy below so the finalizer-removal Update doesn't lose
+		// to a conflict against our now-stale db.
+		db = updated
+	}
+
+	if c.reclaimPolicy(db) == ReclaimPolicyRetain {
+		msg := fmt.Sprintf("skipping drop of database %q: deletion protection is enabled", db.Name)
+		c.logger.Warning(msg)
+		c.recorder.Event(db, corev1.EventTypeWarning, "DeletionProtected", msg)
+	} else if err := c.dropDatabase(key, db); err != nil {
+		msg := fmt.Sprintf("error dropping database '%s': %s", key, err)
+		c.logger.Error(msg)
+		c.recorder.Event(db, corev1.EventTypeWarning, StateError, msg)
+		return err
+	}
+
+	copy := db.DeepCopy()
+	copy.ObjectMeta.Finalizers = removeString(copy.ObjectMeta.Finalizers, DatabaseFinalizer)
+	_, err := c.atlasclientset.AtlasdbV1alpha1().Databases(db.Namespace).Update(copy)
+	return err
+}
+
+// reclaimPolicy returns the effective reclaim policy for db: the per-resource
+// annotation if set and valid, otherwise the operator's
+// object-deletion-protection flag (protection on == Retain). An unrecognized
+// annotation value fails safe to Retain rather than risking an unintended
+// drop of the underlying database.
+func (c *Controller) reclaimPolicy(db *atlas.Database) string {
+	if policy, ok := db.ObjectMeta.Annotations[ReclaimPolicyAnnotation]; ok {
+		switch policy {
+		case ReclaimPolicyRetain, ReclaimPolicyDelete:
+			return policy
+		default:
+			c.logger.Warningf("database '%s/%s' has invalid %s annotation %q, defaulting to %s", db.Namespace, db.Name, ReclaimPolicyAnnotation, policy, ReclaimPolicyRetain)
+			return ReclaimPolicyRetain
+		}
+	}
+	if c.objectDeletionProtection {
+		return ReclaimPolicyRetain
+	}
+	return ReclaimPolicyDelete
+}
+
+// dropDatabase re-resolves the plugin and dsn for db, mirroring the
+// server/serverType selection in syncDatabase, and asks the plugin to drop
+// the underlying database.
+func (c *Controller) dropDatabase(key string, db *atlas.Database) error {
+	var s *atlas.DatabaseServer
+	var err error
+	if db.Spec.Server != "" {
+		s, err = c.serversLister.DatabaseServers(db.Namespace).Get(db.Spec.Server)
+		if err != nil {
+			return err
+		}
+	}
+
+	var p plugin.DatabasePlugin
+	if db.Spec.ServerType != "" {
+		p = server.NewDBPlugin(db.Spec.ServerType)
+	} else if s != nil {
+		p = server.ActivePlugin(s).DatabasePlugin()
+	}
+	if p == nil {
+		return fmt.Errorf("database '%s' does not have a valid database plugin", key)
+	}
+
+	dsn := db.Spec.Dsn
+	if dsn == "" {
+		if db.Spec.DsnFrom != nil && db.Spec.DsnFrom.VaultKeyRef != nil {
+			secret, vaultErr := c.vaultClient.ReadKeyRef(db.Spec.DsnFrom.VaultKeyRef)
+			if vaultErr != nil {
+				return fmt.Errorf("failed to get valid DSN for database '%s' from vault path '%s': %s", key, db.Spec.DsnFrom.VaultKeyRef.Path, vaultErr)
+			}
+			dsn = secret.Value
+		} else if db.Spec.DsnFrom != nil {
+			dsn, err = c.getSecretFromValueSource(db.Namespace, db.Spec.DsnFrom)
+		} else if s != nil {
+			dsn, err = c.getSecretByName(db.Namespace, "dsn", s.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.DropDatabase(db, dsn)
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(slice []string, s string) []string {
+	out := make([]string, 0, len(slice))
+	for _, item := range slice {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// checkDrift asks the plugin to diff db's declared spec against the live
+// server and records a non-empty result on Status.Drift; an empty report
+// leaves Status.Drift as it was. It's called on every sync,
+// but in practice mostly surfaces changes on the resyncs pkg/scheduler
+// triggers independently of watch events, since a watch-triggered sync
+// almost always means the spec (and thus the server) is already up to date.
+func (c *Controller) checkDrift(key string, db *atlas.Database, dsn string, p plugin.DatabasePlugin) {
+	report, err := p.Diff(db, dsn)
+	if err != nil {
+		c.logger.Warningf("failed to check drift for database '%s': %s", key, err)
+		return
+	}
+	if report.Empty() {
+		return
+	}
+
+	copy := db.DeepCopy()
+	copy.Status.Drift = &atlas.DriftStatus{
+		MissingUsers:      report.MissingUsers,
+		AlteredPrivileges: report.AlteredPrivileges,
+		MissingExtensions: report.MissingExtensions,
+		ExtraSchemas:      report.ExtraSchemas,
+	}
+	if _, err := c.atlasclientset.AtlasdbV1alpha1().Databases(db.Namespace).Update(copy); err != nil {
+		c.logger.Warningf("failed to record drift status for database '%s': %s", key, err)
+	}
+	c.recorder.Event(db, corev1.EventTypeWarning, "DriftDetected", fmt.Sprintf("database %q has drifted from its declared spec: %+v", key, report))
+}
+
 func (c *Controller) getHostAndPort(dsn string) (host string, port int32) {
 	splitDSN := strings.Split(strings.Split(dsn, "@")[1], "/")[0]
 	host = strings.Split(splitDSN, ":")[0]
@@ -233,18 +602,30 @@ func (c *Controller) getHostAndPort(dsn string) (host string, port int32) {
 }
 
 func (c *Controller) updateDatabaseStatus(key string, db *atlas.Database, state, msg string) (*atlas.Database, error) {
-	copy := db.DeepCopy()
-	copy.Status.State = state
-	copy.Status.Message = msg
-	// Until #38113 is merged, we must use Update instead of UpdateStatus to
-	// update the Status block of the resource. UpdateStatus will not
-	// allow changes to the Spec of the resource, which is ideal for ensuring
-	// nothing other than resource status has been updated.
-	_, err := c.atlasclientset.AtlasdbV1alpha1().Databases(db.Namespace).Update(copy)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// Re-fetch on every attempt via the clientset, not the lister: a
+		// conflict means our copy of db is stale, but the lister is backed
+		// by an informer cache that may not have caught up with the write
+		// that caused the conflict yet, which would just reproduce the same
+		// conflict on retry.
+		latest, err := c.atlasclientset.AtlasdbV1alpha1().Databases(db.Namespace).Get(db.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		copy := latest.DeepCopy()
+		copy.Status.State = state
+		copy.Status.Message = msg
+		// Until #38113 is merged, we must use Update instead of UpdateStatus to
+		// update the Status block of the resource. UpdateStatus will not
+		// allow changes to the Spec of the resource, which is ideal for ensuring
+		// nothing other than resource status has been updated.
+		_, err = c.atlasclientset.AtlasdbV1alpha1().Databases(db.Namespace).Update(copy)
+		return err
+	})
 	if err != nil {
 		c.logger.Warningf("error updating status to '%s' for database '%s': %s", state, key, err)
 		return db, err
 	}
 	// we have to pull it back out or our next update will fail. hopefully this is fixed with updateStatus
-	return c.dbsLister.Databases(db.Namespace).Get(db.Name)
+	return c.atlasclientset.AtlasdbV1alpha1().Databases(db.Namespace).Get(db.Name, metav1.GetOptions{})
 }
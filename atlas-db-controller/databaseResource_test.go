@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	atlas "github.com/infobloxopen/atlas-db/pkg/apis/db/v1alpha1"
+	fakeclientset "github.com/infobloxopen/atlas-db/pkg/client/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestUpdateDatabaseStatusRetriesOnConflict asserts that updateDatabaseStatus
+// re-fetches the Database from the clientset and re-applies the status
+// mutation until the Update succeeds, rather than giving up after a single
+// conflict or retrying against a cache that hasn't caught up yet.
+func TestUpdateDatabaseStatusRetriesOnConflict(t *testing.T) {
+	db := &atlas.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "mydb", Namespace: "default"},
+	}
+
+	clientset := fakeclientset.NewSimpleClientset(db)
+
+	conflicts := 2
+	attempts := 0
+	clientset.PrependReactor("update", "databases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts <= conflicts {
+			return true, nil, errors.NewConflict(
+				atlas.SchemeGroupVersion.WithResource("databases").GroupResource(),
+				db.Name,
+				nil,
+			)
+		}
+		return false, nil, nil
+	})
+
+	c := &Controller{
+		atlasclientset: clientset,
+		logger:         logrus.New(),
+		recorder:       record.NewFakeRecorder(10),
+	}
+
+	updated, err := c.updateDatabaseStatus("default/mydb", db, StateSuccess, "synced")
+	if err != nil {
+		t.Fatalf("updateDatabaseStatus returned error after retrying: %s", err)
+	}
+	if updated.Status.State != StateSuccess {
+		t.Fatalf("expected state %q, got %q", StateSuccess, updated.Status.State)
+	}
+	if attempts <= conflicts {
+		t.Fatalf("expected at least %d attempts, got %d", conflicts+1, attempts)
+	}
+}
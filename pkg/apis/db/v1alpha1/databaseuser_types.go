@@ -0,0 +1,113 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DatabaseUser manages a single role on a Database, independently of the
+// Database's own lifecycle. Database.Spec.Users is deprecated in favor of
+// creating one DatabaseUser per role.
+type DatabaseUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseUserSpec   `json:"spec"`
+	Status DatabaseUserStatus `json:"status,omitempty"`
+}
+
+// DatabaseUserSpec describes the role to create on Database and how to
+// source or generate its password.
+type DatabaseUserSpec struct {
+	// Database is the name of the Database resource this user belongs to,
+	// in the same namespace as the DatabaseUser.
+	Database string `json:"database"`
+
+	// Username is the role name created on the database server.
+	Username string `json:"username"`
+
+	// Role selects the privilege set granted to Username: "readonly",
+	// "readwrite", "admin", or "custom" (paired with Grants).
+	Role string `json:"role"`
+
+	// Grants lists additional GRANT statements applied when Role is
+	// "custom". Ignored otherwise.
+	Grants []string `json:"grants,omitempty"`
+
+	// Password is used verbatim when set. Exactly one of Password,
+	// PasswordFrom should be set; if neither is, a password is generated
+	// and written back via PasswordFrom.
+	Password string `json:"password,omitempty"`
+
+	// PasswordFrom sources the password from a Secret or, via VaultKeyRef,
+	// from a Vault path. When Password and
+	// PasswordFrom are both empty, the controller generates a password and
+	// populates a Secret referenced here.
+	PasswordFrom *ValueSource `json:"passwordFrom,omitempty"`
+}
+
+// DatabaseUserStatus reports the observed state of a DatabaseUser, mirroring
+// DatabaseStatus.
+type DatabaseUserStatus struct {
+	State   string `json:"state,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// DatabaseUserList is a list of DatabaseUser resources.
+type DatabaseUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []DatabaseUser `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object. Normally produced by
+// deepcopy-gen alongside the rest of this package's zz_generated file.
+func (in *DatabaseUser) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(DatabaseUser)
+	*out = *in
+	out.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	if in.Spec.PasswordFrom != nil {
+		out.Spec.PasswordFrom = in.Spec.PasswordFrom.DeepCopy()
+	}
+	if in.Spec.Grants != nil {
+		out.Spec.Grants = append([]string(nil), in.Spec.Grants...)
+	}
+	return out
+}
+
+// DeepCopyObject implements runtime.Object.
+func (in *DatabaseUserList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := new(DatabaseUserList)
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]DatabaseUser, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+	return out
+}
+
+// DeepCopyInto copies the receiver into out.
+func (in *DatabaseUser) DeepCopyInto(out *DatabaseUser) {
+	*out = *in.DeepCopyObject().(*DatabaseUser)
+}
+
+// DeepCopy returns a deep copy of the receiver.
+func (in *DatabaseUser) DeepCopy() *DatabaseUser {
+	if in == nil {
+		return nil
+	}
+	return in.DeepCopyObject().(*DatabaseUser)
+}
@@ -0,0 +1,12 @@
+package v1alpha1
+
+// DriftStatus is the last non-empty drift report observed for a Database. It
+// is written by every Database sync, whether triggered by a watch event or by
+// the scheduled drift-detection resync (see pkg/scheduler); a sync that finds
+// no drift leaves it unchanged. Its fields mirror plugin.DriftReport.
+type DriftStatus struct {
+	MissingUsers      []string `json:"missingUsers,omitempty"`
+	AlteredPrivileges []string `json:"alteredPrivileges,omitempty"`
+	MissingExtensions []string `json:"missingExtensions,omitempty"`
+	ExtraSchemas      []string `json:"extraSchemas,omitempty"`
+}
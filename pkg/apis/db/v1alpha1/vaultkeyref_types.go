@@ -0,0 +1,30 @@
+package v1alpha1
+
+// VaultKeyRef selects a field from a HashiCorp Vault path as a credential
+// source, as an alternative to ValueSource.SecretKeyRef. It is set on
+// ValueSource alongside SecretKeyRef; exactly one of the two should be set.
+type VaultKeyRef struct {
+	// Addr is the Vault server address, e.g. "https://vault.infra:8200". If
+	// empty, the operator's --vault-addr default is used.
+	Addr string `json:"addr,omitempty"`
+
+	// Path is the Vault path to read, e.g. "database/creds/readonly" for
+	// the database secrets engine or "secret/data/myapp/db" for KV v2.
+	Path string `json:"path"`
+
+	// Field is the key within the secret's data to use as the value.
+	Field string `json:"field"`
+
+	// AuthMethod selects how the controller authenticates to Vault:
+	// "kubernetes" (default, via the pod's projected ServiceAccount JWT) or
+	// "approle" (via RoleID/SecretID).
+	AuthMethod string `json:"authMethod,omitempty"`
+
+	// Role is the Vault role to authenticate as when AuthMethod is
+	// "kubernetes".
+	Role string `json:"role,omitempty"`
+
+	// RoleID and SecretID authenticate when AuthMethod is "approle".
+	RoleID   string `json:"roleId,omitempty"`
+	SecretID string `json:"secretId,omitempty"`
+}
@@ -0,0 +1,406 @@
+// Package databaseuser implements the controller for the DatabaseUser CRD.
+//
+// DatabaseUser decouples role/credential lifecycle from the Database it
+// references: a Database can be created once by one team while individual
+// DatabaseUser resources are created, rotated, and dropped independently by
+// whoever owns a particular application's credentials.
+package databaseuser
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	atlas "github.com/infobloxopen/atlas-db/pkg/apis/db/v1alpha1"
+	clientset "github.com/infobloxopen/atlas-db/pkg/client/clientset/versioned"
+	listers "github.com/infobloxopen/atlas-db/pkg/client/listers/db/v1alpha1"
+	"github.com/infobloxopen/atlas-db/pkg/server"
+	"github.com/infobloxopen/atlas-db/pkg/server/plugin"
+	"github.com/infobloxopen/atlas-db/pkg/vault"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Controller state names, mirrored from atlas-db-controller so DatabaseUser
+// and Database resources report status the same way.
+const (
+	StatePending = "Pending"
+	StateError   = "Error"
+	StateSuccess = "Success"
+	StateCreated = "Created"
+
+	ErrResourceExists = "ErrResourceExists"
+
+	MessageSecretCreated      = "Secret %q created successfully"
+	MessageSecretExists       = "Resource %q already exists and is not managed by this DatabaseUser"
+	MessageDatabaseUserSynced = "DatabaseUser %s synced successfully"
+
+	finalizerName = "databaseuser.atlas-db.infobloxopen.com/finalizer"
+
+	// reclaimPolicyAnnotation mirrors atlas-db-controller's
+	// ReclaimPolicyAnnotation, applied to a DatabaseUser instead of a
+	// Database.
+	reclaimPolicyAnnotation = "atlas-db.infobloxopen.com/reclaim-policy"
+
+	reclaimPolicyRetain = "Retain"
+	reclaimPolicyDelete = "Delete"
+)
+
+// Controller reconciles DatabaseUser resources against the Database they
+// reference, following the same sync/status pattern as
+// atlas-db-controller.Controller.syncDatabase.
+type Controller struct {
+	kubeclientset  kubernetes.Interface
+	atlasclientset clientset.Interface
+	logger         logrus.FieldLogger
+	recorder       record.EventRecorder
+	vaultClient    *vault.Client
+
+	dbsLister     listers.DatabaseLister
+	serversLister listers.DatabaseServerLister
+	usersLister   listers.DatabaseUserLister
+	secretsLister corelisters.SecretLister
+
+	queue workqueue.RateLimitingInterface
+
+	// subobjectDeletionProtection is the operator-wide default for
+	// reclaimPolicy; set from the --subobject-deletion-protection flag.
+	subobjectDeletionProtection bool
+}
+
+func (c *Controller) enqueueDatabaseUser(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	c.queue.AddRateLimited(key)
+}
+
+// syncDatabaseUser creates, rotates, or drops the role described by a
+// DatabaseUser, delegating the actual SQL work to the plugin for the
+// Database it references.
+func (c *Controller) syncDatabaseUser(key string) error {
+	c.logger.Infof("Processing database user : %v", key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		c.logger.Errorf("invalid resource key: %s", key)
+		return nil
+	}
+
+	du, err := c.usersLister.DatabaseUsers(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			c.logger.Warningf("database user '%s' in work queue no longer exists", key)
+			return nil
+		}
+		return err
+	}
+
+	db, err := c.dbsLister.Databases(namespace).Get(du.Spec.Database)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			msg := fmt.Sprintf("waiting for database '%s/%s'", namespace, du.Spec.Database)
+			c.logger.Debug(msg)
+			c.updateDatabaseUserStatus(key, du, StatePending, msg)
+			return nil
+		}
+		return err
+	}
+
+	p, err := c.resolvePlugin(db)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		msg := fmt.Sprintf("database user '%s' does not have a valid database plugin", key)
+		c.logger.Error(msg)
+		c.updateDatabaseUserStatus(key, du, StateError, msg)
+		return nil
+	}
+
+	if !du.ObjectMeta.DeletionTimestamp.IsZero() {
+		return c.finalizeDatabaseUser(key, du, db, p)
+	}
+
+	if !containsString(du.ObjectMeta.Finalizers, finalizerName) {
+		copy := du.DeepCopy()
+		copy.ObjectMeta.Finalizers = append(copy.ObjectMeta.Finalizers, finalizerName)
+		du, err = c.atlasclientset.AtlasdbV1alpha1().DatabaseUsers(du.Namespace).Update(copy)
+		if err != nil {
+			return err
+		}
+	}
+
+	passwd := du.Spec.Password
+	switch {
+	case passwd != "":
+		// used as-is
+	case du.Spec.PasswordFrom != nil && du.Spec.PasswordFrom.VaultKeyRef != nil:
+		var secret *vault.Secret
+		secret, err = c.vaultClient.ReadKeyRef(du.Spec.PasswordFrom.VaultKeyRef)
+		if err != nil {
+			msg := fmt.Sprintf("failed to get valid password for '%s' from vault path '%s': %s", key, du.Spec.PasswordFrom.VaultKeyRef.Path, err)
+			c.logger.Error(msg)
+			c.updateDatabaseUserStatus(key, du, StateError, msg)
+			return err
+		}
+		passwd = secret.Value
+	case du.Spec.PasswordFrom != nil:
+		passwd, err = c.getSecretFromValueSource(du.Namespace, du.Spec.PasswordFrom)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				msg := fmt.Sprintf("waiting for secret or configmap for %s", du.Spec.Username)
+				c.logger.Debug(msg)
+				c.updateDatabaseUserStatus(key, du, StatePending, msg)
+			}
+			return err
+		}
+	default:
+		// Neither Password nor PasswordFrom is set: generate one and write
+		// it back via PasswordFrom so subsequent syncs reuse it instead of
+		// generating a new password every reconcile.
+		passwd, du, err = c.generatePassword(du)
+		if err != nil {
+			msg := fmt.Sprintf("failed to generate password for '%s': %s", key, err)
+			c.logger.Error(msg)
+			c.updateDatabaseUserStatus(key, du, StateError, msg)
+			return err
+		}
+	}
+
+	if err := p.SyncUser(db, du, passwd); err != nil {
+		msg := fmt.Sprintf("error syncing database user '%s': %s", key, err)
+		c.logger.Error(msg)
+		c.updateDatabaseUserStatus(key, du, StateError, msg)
+		return err
+	}
+
+	if err := c.syncDatabaseUserSecret(key, du, passwd); err != nil {
+		msg := fmt.Sprintf("error syncing database user secret '%s': %s", key, err)
+		c.updateDatabaseUserStatus(key, du, StateError, msg)
+		return nil
+	}
+
+	c.updateDatabaseUserStatus(key, du, StateSuccess, fmt.Sprintf(MessageDatabaseUserSynced, key))
+	return nil
+}
+
+// resolvePlugin mirrors atlas-db-controller.Controller.syncDatabase's
+// server/serverType selection: db.Spec.ServerType is used directly if set,
+// otherwise the plugin comes from the DatabaseServer db.Spec.Server points
+// at.
+func (c *Controller) resolvePlugin(db *atlas.Database) (plugin.DatabasePlugin, error) {
+	if db.Spec.ServerType != "" {
+		return server.NewDBPlugin(db.Spec.ServerType), nil
+	}
+	if db.Spec.Server == "" {
+		return nil, nil
+	}
+	s, err := c.serversLister.DatabaseServers(db.Namespace).Get(db.Spec.Server)
+	if err != nil {
+		return nil, err
+	}
+	return server.ActivePlugin(s).DatabasePlugin(), nil
+}
+
+// generatedPasswordKey is the Secret data key generatePassword writes the
+// password under, and the key PasswordFrom.SecretKeyRef points back at.
+const generatedPasswordKey = "password"
+
+// generatePassword creates a random password for du, stores it in a Secret
+// owned by du, and points du.Spec.PasswordFrom at that Secret so the same
+// password is reused on every subsequent sync instead of being regenerated
+// (and re-rotated on the server) each reconcile. It returns the plaintext
+// password and the updated du.
+func (c *Controller) generatePassword(du *atlas.DatabaseUser) (string, *atlas.DatabaseUser, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", du, err
+	}
+	passwd := base64.RawURLEncoding.EncodeToString(buf)
+
+	secretName := du.Name + "-password"
+	_, err := c.kubeclientset.CoreV1().Secrets(du.Namespace).Create(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            secretName,
+				Namespace:       du.Namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(du, atlas.SchemeGroupVersion.WithKind("DatabaseUser"))},
+			},
+			StringData: map[string]string{
+				generatedPasswordKey: passwd,
+			},
+		},
+	)
+	if errors.IsAlreadyExists(err) {
+		// A prior reconcile created the Secret but didn't make it to the
+		// PasswordFrom Update below (error, or the process restarted in
+		// between); reuse what's already there instead of generating a new
+		// password the live role doesn't have.
+		existing, getErr := c.kubeclientset.CoreV1().Secrets(du.Namespace).Get(secretName, metav1.GetOptions{})
+		if getErr != nil {
+			return "", du, getErr
+		}
+		passwd = string(existing.Data[generatedPasswordKey])
+	} else if err != nil {
+		return "", du, err
+	}
+
+	copy := du.DeepCopy()
+	copy.Spec.PasswordFrom = &atlas.ValueSource{
+		SecretKeyRef: &atlas.SecretKeyRef{
+			Name: secretName,
+			Key:  generatedPasswordKey,
+		},
+	}
+	updated, err := c.atlasclientset.AtlasdbV1alpha1().DatabaseUsers(du.Namespace).Update(copy)
+	if err != nil {
+		return "", du, err
+	}
+	return passwd, updated, nil
+}
+
+// finalizeDatabaseUser drops the role, unless sub-object deletion protection
+// keeps it in place, and removes our finalizer so the DatabaseUser object
+// itself can be garbage collected.
+func (c *Controller) finalizeDatabaseUser(key string, du *atlas.DatabaseUser, db *atlas.Database, p plugin.DatabasePlugin) error {
+	if !containsString(du.ObjectMeta.Finalizers, finalizerName) {
+		return nil
+	}
+
+	if c.reclaimPolicy(du) == reclaimPolicyRetain {
+		msg := fmt.Sprintf("skipping drop of database user %q: deletion protection is enabled", du.Spec.Username)
+		c.logger.Warning(msg)
+		c.recorder.Event(du, corev1.EventTypeWarning, "DeletionProtected", msg)
+	} else if err := p.DropUser(db, du); err != nil {
+		msg := fmt.Sprintf("error dropping database user '%s': %s", key, err)
+		c.logger.Error(msg)
+		c.updateDatabaseUserStatus(key, du, StateError, msg)
+		return err
+	}
+
+	copy := du.DeepCopy()
+	copy.ObjectMeta.Finalizers = removeString(copy.ObjectMeta.Finalizers, finalizerName)
+	_, err := c.atlasclientset.AtlasdbV1alpha1().DatabaseUsers(du.Namespace).Update(copy)
+	return err
+}
+
+// reclaimPolicy returns the effective reclaim policy for du: the per-resource
+// annotation if set and valid, otherwise the operator's
+// subobject-deletion-protection flag (protection on == Retain). An
+// unrecognized annotation value fails safe to Retain rather than risking an
+// unintended drop of the underlying role.
+func (c *Controller) reclaimPolicy(du *atlas.DatabaseUser) string {
+	if policy, ok := du.ObjectMeta.Annotations[reclaimPolicyAnnotation]; ok {
+		switch policy {
+		case reclaimPolicyRetain, reclaimPolicyDelete:
+			return policy
+		default:
+			c.logger.Warningf("database user '%s/%s' has invalid %s annotation %q, defaulting to %s", du.Namespace, du.Name, reclaimPolicyAnnotation, policy, reclaimPolicyRetain)
+			return reclaimPolicyRetain
+		}
+	}
+	if c.subobjectDeletionProtection {
+		return reclaimPolicyRetain
+	}
+	return reclaimPolicyDelete
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// syncDatabaseUserSecret writes a per-user connection Secret the first time a
+// DatabaseUser is synced, and keeps it in sync with passwd on every
+// subsequent reconcile so a rotated password (generated, or re-read from
+// PasswordFrom) actually reaches the Secret consumers use.
+func (c *Controller) syncDatabaseUserSecret(key string, du *atlas.DatabaseUser, passwd string) error {
+	secret, err := c.secretsLister.Secrets(du.Namespace).Get(du.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if errors.IsNotFound(err) {
+		secret, err = c.kubeclientset.CoreV1().Secrets(du.Namespace).Create(
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            du.Name,
+					Namespace:       du.Namespace,
+					OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(du, atlas.SchemeGroupVersion.WithKind("DatabaseUser"))},
+				},
+				StringData: map[string]string{
+					"username": du.Spec.Username,
+					"password": passwd,
+				},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		c.recorder.Event(du, corev1.EventTypeNormal, StateCreated, fmt.Sprintf(MessageSecretCreated, secret.Name))
+		return nil
+	}
+
+	if !metav1.IsControlledBy(secret, du) {
+		msg := fmt.Sprintf(MessageSecretExists, secret.Name)
+		c.recorder.Event(du, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return fmt.Errorf(msg)
+	}
+
+	if string(secret.Data["username"]) == du.Spec.Username && string(secret.Data["password"]) == passwd {
+		return nil
+	}
+
+	copy := secret.DeepCopy()
+	copy.StringData = map[string]string{
+		"username": du.Spec.Username,
+		"password": passwd,
+	}
+	if _, err := c.kubeclientset.CoreV1().Secrets(du.Namespace).Update(copy); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Controller) updateDatabaseUserStatus(key string, du *atlas.DatabaseUser, state, msg string) (*atlas.DatabaseUser, error) {
+	copy := du.DeepCopy()
+	copy.Status.State = state
+	copy.Status.Message = msg
+	_, err := c.atlasclientset.AtlasdbV1alpha1().DatabaseUsers(du.Namespace).Update(copy)
+	if err != nil {
+		c.logger.Warningf("error updating status to '%s' for database user '%s': %s", state, key, err)
+		return du, err
+	}
+	return c.usersLister.DatabaseUsers(du.Namespace).Get(du.Name)
+}
+
+func (c *Controller) getSecretFromValueSource(namespace string, src *atlas.ValueSource) (string, error) {
+	secret, err := c.secretsLister.Secrets(namespace).Get(src.SecretKeyRef.Name)
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Data[src.SecretKeyRef.Key]), nil
+}
+
+func removeString(slice []string, s string) []string {
+	out := make([]string, 0, len(slice))
+	for _, item := range slice {
+		if item != s {
+			out = append(out, item)
+		}
+	}
+	return out
+}
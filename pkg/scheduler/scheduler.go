@@ -0,0 +1,128 @@
+// Package scheduler periodically re-enqueues resources for reconciliation
+// independent of Kubernetes watch events, so that drift introduced directly
+// on a database server (not through the Database/DatabaseUser CRs) is caught
+// even though nothing about the CR itself changed.
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// IntervalAnnotation overrides DefaultInterval for a single resource, e.g.
+// "atlas-db.infobloxopen.com/drift-resync-interval: 5m".
+const IntervalAnnotation = "atlas-db.infobloxopen.com/drift-resync-interval"
+
+// Scheduler runs one job per resource kind that lists every object of that
+// kind on a fixed tick and enqueues each one whose interval is due into the
+// kind's workqueue. It does
+// not replace the informer-driven watch path; it only makes sure every
+// object gets reconciled at least once per interval even with no watch
+// events.
+type Scheduler struct {
+	logger          logrus.FieldLogger
+	defaultInterval time.Duration
+
+	mu      sync.Mutex
+	nextRun map[string]time.Time
+}
+
+// New returns a Scheduler using defaultInterval for resources that don't set
+// IntervalAnnotation.
+func New(logger logrus.FieldLogger, defaultInterval time.Duration) *Scheduler {
+	return &Scheduler{logger: logger, defaultInterval: defaultInterval, nextRun: map[string]time.Time{}}
+}
+
+// Job describes one resource kind to drift-resync: list returns every
+// current object, and queue is where their keys are enqueued.
+type Job struct {
+	Name  string
+	List  func() ([]metav1.Object, error)
+	Queue workqueue.RateLimitingInterface
+}
+
+// Run starts one goroutine per job that ticks at the shorter of
+// s.defaultInterval and tickInterval, re-listing on every tick and enqueuing
+// the objects that are due, until stopCh is closed.
+// workqueue.Add is a no-op for a key that's already queued, so a resource
+// that already has a pending sync from a watch event is naturally coalesced
+// rather than double-processed.
+func (s *Scheduler) Run(stopCh <-chan struct{}, jobs ...Job) {
+	for _, job := range jobs {
+		go s.runJob(stopCh, job)
+	}
+}
+
+// tickInterval is the granularity Run polls at; it caps at a minute so a
+// short per-object IntervalAnnotation is still observed reasonably promptly.
+const tickInterval = time.Minute
+
+func (s *Scheduler) runJob(stopCh <-chan struct{}, job Job) {
+	interval := tickInterval
+	if s.defaultInterval < interval {
+		interval = s.defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			s.resync(job)
+		}
+	}
+}
+
+func (s *Scheduler) resync(job Job) {
+	objs, err := job.List()
+	if err != nil {
+		s.logger.Warningf("drift scheduler: failed to list %s: %s", job.Name, err)
+		return
+	}
+
+	now := time.Now()
+	for _, obj := range objs {
+		key, err := cache.MetaNamespaceKeyFunc(obj)
+		if err != nil {
+			continue
+		}
+		if !s.due(job.Name, key, obj, now) {
+			continue
+		}
+		job.Queue.Add(key)
+	}
+}
+
+// due reports whether key's drift-resync interval has elapsed since it was
+// last enqueued for jobName (a key not yet enqueued is due on its first
+// tick), and if so advances its next-run time. jobName is part of the
+// nextRun key because keys are only unique within a job: a Database and a
+// DatabaseServer with the same namespace/name would otherwise collide on one
+// entry.
+func (s *Scheduler) due(jobName, key string, obj metav1.Object, now time.Time) bool {
+	interval := s.defaultInterval
+	if raw, ok := obj.GetAnnotations()[IntervalAnnotation]; ok {
+		if parsed, err := time.ParseDuration(raw); err == nil {
+			interval = parsed
+		} else {
+			s.logger.Warningf("drift scheduler: invalid %s annotation on %s/%s: %s", IntervalAnnotation, obj.GetNamespace(), obj.GetName(), raw)
+		}
+	}
+
+	nextRunKey := jobName + "/" + key
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Before(s.nextRun[nextRunKey]) {
+		return false
+	}
+	s.nextRun[nextRunKey] = now.Add(interval)
+	return true
+}
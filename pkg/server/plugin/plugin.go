@@ -0,0 +1,70 @@
+// Package plugin defines the interface atlas-db's controllers use to talk to
+// a specific kind of database server (e.g. Postgres, MySQL), independent of
+// how that server was reached (inline serverType or a DatabaseServer CR).
+package plugin
+
+import (
+	atlas "github.com/infobloxopen/atlas-db/pkg/apis/db/v1alpha1"
+)
+
+// DatabasePlugin performs the server-specific work backing the Database and
+// DatabaseUser controllers.
+type DatabasePlugin interface {
+	// SyncDatabase creates or reconciles the database described by db,
+	// connecting with dsn. It returns a state (e.g. StateCreated) the
+	// caller may use to decide whether to emit a creation event.
+	SyncDatabase(db *atlas.Database, dsn string) (string, error)
+
+	// Dsn builds a connection string for user/passwd against db, using
+	// dbServer for host/port.
+	Dsn(user, passwd string, db *atlas.Database, dbServer *atlas.DatabaseServer) string
+
+	// Protocol returns the connection protocol this plugin builds DSNs for,
+	// e.g. "postgres" or "mysql". Used to populate secretTemplateContext and
+	// to pick the right JDBC URL scheme.
+	Protocol() string
+
+	// DropDatabase removes the database described by db, connecting with
+	// dsn. Callers are responsible for honoring deletion-protection checks
+	// before calling this.
+	DropDatabase(db *atlas.Database, dsn string) error
+
+	// SyncUser creates or reconciles the role described by du on db,
+	// granting the privileges implied by du.Spec.Role/Grants. It must be
+	// idempotent: calling it again for an unchanged spec should be a no-op.
+	SyncUser(db *atlas.Database, du *atlas.DatabaseUser, passwd string) error
+
+	// DropUser removes the role described by du from db.
+	DropUser(db *atlas.Database, du *atlas.DatabaseUser) error
+
+	// Diff compares db's declared spec against what's actually on the
+	// server, connecting with dsn. It reports changes made out-of-band
+	// (directly on the server) that the controller wouldn't otherwise
+	// notice since nothing about the Database resource itself changed.
+	Diff(db *atlas.Database, dsn string) (*DriftReport, error)
+}
+
+// DriftReport is the structured result of DatabasePlugin.Diff.
+type DriftReport struct {
+	// MissingUsers lists users declared in db.Spec.Users that no longer
+	// exist on the server.
+	MissingUsers []string `json:"missingUsers,omitempty"`
+
+	// AlteredPrivileges lists users whose granted privileges no longer
+	// match their declared Role.
+	AlteredPrivileges []string `json:"alteredPrivileges,omitempty"`
+
+	// MissingExtensions lists extensions declared on the Database that are
+	// no longer installed.
+	MissingExtensions []string `json:"missingExtensions,omitempty"`
+
+	// ExtraSchemas lists schemas present on the server that aren't declared
+	// anywhere in the Database spec.
+	ExtraSchemas []string `json:"extraSchemas,omitempty"`
+}
+
+// Empty reports whether the drift report found nothing to flag.
+func (r *DriftReport) Empty() bool {
+	return r == nil || (len(r.MissingUsers) == 0 && len(r.AlteredPrivileges) == 0 &&
+		len(r.MissingExtensions) == 0 && len(r.ExtraSchemas) == 0)
+}
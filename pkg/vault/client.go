@@ -0,0 +1,134 @@
+// Package vault provides a thin client over HashiCorp Vault for resolving
+// DSNs and passwords sourced from a VaultKeyRef, authenticating with the
+// controller's own projected ServiceAccount token or with AppRole.
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+
+	atlas "github.com/infobloxopen/atlas-db/pkg/apis/db/v1alpha1"
+)
+
+// defaultServiceAccountTokenPath is where kubelet projects the pod's
+// ServiceAccount JWT when VaultKeyRef.AuthMethod is "kubernetes".
+const defaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Secret is a value read from Vault along with how long it remains valid.
+// LeaseDuration is zero for static KV reads and non-zero for
+// database-secrets-engine leases that the caller should renew or re-read
+// before expiry.
+type Secret struct {
+	Value         string
+	LeaseID       string
+	LeaseDuration time.Duration
+}
+
+// Client authenticates to Vault and reads KV or database-secrets-engine
+// paths referenced by a Database's DsnFrom/PasswordFrom.
+type Client struct {
+	addr string
+	role string
+
+	tokenPath string
+}
+
+// New returns a Client for the Vault server at addr, authenticating with
+// role via the Kubernetes auth method.
+func New(addr, role string) *Client {
+	return &Client{addr: addr, role: role, tokenPath: defaultServiceAccountTokenPath}
+}
+
+// ReadKeyRef authenticates with ref.AuthMethod (see login) and reads the
+// field at ref.Path, returning its value and lease information.
+func (c *Client) ReadKeyRef(ref *atlas.VaultKeyRef) (*Secret, error) {
+	addr := c.addr
+	if ref.Addr != "" {
+		addr = ref.Addr
+	}
+	client, err := vaultapi.NewClient(&vaultapi.Config{Address: addr})
+	if err != nil {
+		return nil, fmt.Errorf("vault: failed to create client: %s", err)
+	}
+
+	token, err := c.login(client, ref)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(token)
+
+	secret, err := client.Logical().Read(ref.Path)
+	if err != nil {
+		return nil, fmt.Errorf("vault: failed to read %q: %s", ref.Path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("vault: no data at %q", ref.Path)
+	}
+
+	data := secret.Data
+	// The versioned KV v2 engine nests the actual fields one level deeper,
+	// under "data"; KV v1 and the database secrets engine return them at the
+	// top level.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	raw, ok := data[ref.Field]
+	if !ok {
+		return nil, fmt.Errorf("vault: field %q not found at %q", ref.Field, ref.Path)
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return nil, fmt.Errorf("vault: field %q at %q is not a string", ref.Field, ref.Path)
+	}
+
+	return &Secret{
+		Value:         value,
+		LeaseID:       secret.LeaseID,
+		LeaseDuration: time.Duration(secret.LeaseDuration) * time.Second,
+	}, nil
+}
+
+// login authenticates to Vault using ref.AuthMethod ("kubernetes" or
+// "approle") and returns a client token.
+func (c *Client) login(client *vaultapi.Client, ref *atlas.VaultKeyRef) (string, error) {
+	role := c.role
+	if ref.Role != "" {
+		role = ref.Role
+	}
+	switch ref.AuthMethod {
+	case "", "kubernetes":
+		jwt, err := ioutil.ReadFile(c.tokenPath)
+		if err != nil {
+			return "", fmt.Errorf("vault: failed to read service account token: %s", err)
+		}
+		secret, err := client.Logical().Write("auth/kubernetes/login", map[string]interface{}{
+			"role": role,
+			"jwt":  string(jwt),
+		})
+		if err != nil {
+			return "", fmt.Errorf("vault: kubernetes auth failed: %s", err)
+		}
+		if secret == nil || secret.Auth == nil {
+			return "", fmt.Errorf("vault: kubernetes auth for role %q returned no auth info", role)
+		}
+		return secret.Auth.ClientToken, nil
+	case "approle":
+		secret, err := client.Logical().Write("auth/approle/login", map[string]interface{}{
+			"role_id":   ref.RoleID,
+			"secret_id": ref.SecretID,
+		})
+		if err != nil {
+			return "", fmt.Errorf("vault: approle auth failed: %s", err)
+		}
+		if secret == nil || secret.Auth == nil {
+			return "", fmt.Errorf("vault: approle auth returned no auth info")
+		}
+		return secret.Auth.ClientToken, nil
+	default:
+		return "", fmt.Errorf("vault: unsupported auth method %q", ref.AuthMethod)
+	}
+}